@@ -0,0 +1,68 @@
+package queue
+
+// Checkpointer is implemented by storage backends that can force their
+// state to disk (or whatever durable medium they use) outside of their
+// normal write path, e.g. before a planned shutdown.
+type Checkpointer interface {
+	Checkpoint() error
+}
+
+// Recoverer is implemented by durable storage backends. Recover restores
+// previously persisted state and re-enqueues requests that were leased
+// via LeaseStorage.GetLeasedRequest but never Ack'd, so a crashed worker
+// doesn't silently drop work. Backends with a visibility timeout (the
+// redis and redisstream packages) re-enqueue only leases older than it.
+type Recoverer interface {
+	Recover() error
+}
+
+// Closer is implemented by storage backends that hold external resources
+// (file handles, network connections) that must be released when a
+// Queue is done with them.
+type Closer interface {
+	Close() error
+}
+
+// BatchStorage is implemented by storage backends where fetching several
+// requests in one round trip is cheaper than fetching them one at a
+// time (e.g. Redis Streams). Queue.Run's dispatcher prefers it over
+// Storage.GetRequest when the backend advertises it.
+type BatchStorage interface {
+	// BatchGetRequest pops up to n requests in a single round trip. It
+	// may return fewer than n (including zero) if that's all that's
+	// available; it never waits for n to accumulate, though it may wait
+	// a short, bounded time when none is available.
+	BatchGetRequest(n int) ([][]byte, error)
+}
+
+// Notifier is implemented by storage backends that can signal the
+// dispatcher directly instead of it polling IsEmpty. Notify returns a
+// channel that fires (a non-blocking send) whenever the backend
+// transitions from empty to non-empty.
+type Notifier interface {
+	Notify() <-chan struct{}
+}
+
+// FrontStorage is implemented by storage backends that can re-enqueue a
+// request ahead of everything else already queued. Queue.Stop(true) uses
+// it to put back a request that was fetched from storage but never
+// dispatched, so it's the next thing processed on a future Run.
+type FrontStorage interface {
+	AddRequestFront([]byte) error
+}
+
+// LeaseStorage is implemented by durable storage backends that can track
+// a popped-but-not-yet-finished request as leased rather than gone, so it
+// can be redelivered by Recover if the worker holding it dies. Storage
+// backends that don't implement it (e.g. InMemoryQueueStorage) are
+// fire-and-forget: Queue.Run falls back to plain GetRequest and never
+// calls Ack.
+type LeaseStorage interface {
+	// GetLeasedRequest pops the next request the same way
+	// Storage.GetRequest does, but returns a lease id that must be
+	// passed to Ack once the request has been fully processed.
+	GetLeasedRequest() ([]byte, uint64, error)
+	// Ack marks a leased request as done, permanently removing it from
+	// the backing store.
+	Ack(id uint64) error
+}
@@ -0,0 +1,16 @@
+package queue
+
+import "errors"
+
+// ErrQueueFull is returned by AddRequest/AddURL when the queue has no
+// room for another request and QueueOptions.BlockOnFull is false.
+var ErrQueueFull = errors.New("queue: at capacity")
+
+// ErrCanceled is returned by RunContext when it stops because its
+// context was canceled or Stop was called, rather than because storage
+// ran out of requests.
+var ErrCanceled = errors.New("queue: run canceled")
+
+// ErrAllWorkersDied is returned by RunContext when it has no consumer
+// threads to dispatch requests to.
+var ErrAllWorkersDied = errors.New("queue: all workers died")
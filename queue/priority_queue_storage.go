@@ -0,0 +1,261 @@
+package queue
+
+import (
+	"container/heap"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/tylerkovacs/colly/v2"
+)
+
+// PriorityStorage is implemented by storage backends that can order
+// requests by an explicit priority instead of strict FIFO. Callers that
+// only need FIFO semantics can keep using the plain Storage interface;
+// PriorityStorage is an optional extension detected via type assertion.
+type PriorityStorage interface {
+	// AddRequestWithPriority adds a serialized request to the queue with
+	// the given priority. Higher values are dequeued first; requests of
+	// equal priority preserve FIFO order.
+	AddRequestWithPriority([]byte, int) error
+}
+
+// PriorityQueueStorage is a Storage implementation that dequeues the
+// highest-priority request first, round-robining across the hosts whose
+// next request shares that priority so a single hot domain can't starve
+// other hosts of equal priority. Within a host, priority ties are broken
+// by insertion order; across hosts, they are served round-robin.
+type PriorityQueueStorage struct {
+	// MaxSize defines the capacity of the queue. New requests are
+	// discarded once the queue size reaches MaxSize.
+	MaxSize int
+
+	lock     sync.Mutex
+	size     int
+	seq      uint64
+	hosts    map[string]*hostHeap
+	hostRing []string
+	ringPos  int
+}
+
+// priorityItem is a single entry in a per-host heap.
+type priorityItem struct {
+	request  []byte
+	priority int
+	seq      uint64
+}
+
+// priorityByteBias shifts a clamped signed-byte priority into the
+// unsigned range so it can be stored as a single byte.
+const priorityByteBias = 128
+
+// encodePriorityEnvelope prepends a 1-byte priority prefix to data, so
+// that a caller marshaling item.request (e.g. to hand it to a durable
+// Storage backend) doesn't silently lose the priority this queue
+// scheduled it with. priority is clamped to fit a signed byte; ordering
+// within PriorityQueueStorage itself comes from the heap, not this
+// prefix, so the clamp only affects what a future read of the envelope
+// can recover, not in-process scheduling.
+func encodePriorityEnvelope(priority int, data []byte) []byte {
+	if priority > 127 {
+		priority = 127
+	} else if priority < -128 {
+		priority = -128
+	}
+	envelope := make([]byte, len(data)+1)
+	envelope[0] = byte(priority + priorityByteBias)
+	copy(envelope[1:], data)
+	return envelope
+}
+
+// decodePriorityEnvelope strips the leading priority byte added by
+// encodePriorityEnvelope, returning the original serialized request.
+func decodePriorityEnvelope(envelope []byte) []byte {
+	if len(envelope) == 0 {
+		return envelope
+	}
+	return envelope[1:]
+}
+
+// hostHeap is a binary max-heap of priorityItems for a single host,
+// ordered by (priority desc, seq asc) so equal-priority items stay FIFO.
+type hostHeap []*priorityItem
+
+func (h hostHeap) Len() int { return len(h) }
+
+func (h hostHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h hostHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *hostHeap) Push(x interface{}) {
+	*h = append(*h, x.(*priorityItem))
+}
+
+func (h *hostHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// Init implements Storage.Init.
+func (q *PriorityQueueStorage) Init() error {
+	q.hosts = make(map[string]*hostHeap)
+	q.hostRing = nil
+	q.ringPos = 0
+	return nil
+}
+
+// AddRequest implements Storage.AddRequest, enqueueing with priority 0.
+func (q *PriorityQueueStorage) AddRequest(r []byte) error {
+	return q.AddRequestWithPriority(r, 0)
+}
+
+// AddRequestWithPriority implements PriorityStorage.AddRequestWithPriority.
+// The request's host is extracted from the raw, unwrapped bytes so it can
+// be scheduled round-robin alongside the other hosts currently queued;
+// the stored copy is then wrapped with encodePriorityEnvelope so priority
+// isn't lost if this storage is ever combined with persistence.
+func (q *PriorityQueueStorage) AddRequestWithPriority(r []byte, priority int) error {
+	host, err := requestHost(r)
+	if err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.MaxSize > 0 && q.size >= q.MaxSize {
+		return nil
+	}
+
+	h, ok := q.hosts[host]
+	if !ok {
+		h = &hostHeap{}
+		heap.Init(h)
+		q.hosts[host] = h
+		q.hostRing = append(q.hostRing, host)
+	}
+	q.seq++
+	heap.Push(h, &priorityItem{request: encodePriorityEnvelope(priority, r), priority: priority, seq: q.seq})
+	q.size++
+	return nil
+}
+
+// GetRequest implements Storage.GetRequest. It advances a ring of active
+// hosts and pops the top item of the next host whose top item has the
+// highest priority currently queued, so hosts tied at that priority take
+// turns instead of one monopolizing the worker pool. The priority
+// byte prefix added by encodePriorityEnvelope is stripped before the
+// request is returned, so callers see the same bytes they originally
+// passed to AddRequestWithPriority.
+func (q *PriorityQueueStorage) GetRequest() ([]byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.size == 0 {
+		return nil, nil
+	}
+
+	best, found := 0, false
+	for _, h := range q.hosts {
+		if h.Len() > 0 && (!found || (*h)[0].priority > best) {
+			best, found = (*h)[0].priority, true
+		}
+	}
+
+	for i := 0; i < len(q.hostRing); i++ {
+		pos := (q.ringPos + i) % len(q.hostRing)
+		host := q.hostRing[pos]
+		h := q.hosts[host]
+		if h.Len() == 0 || (*h)[0].priority != best {
+			continue
+		}
+		item := heap.Pop(h).(*priorityItem)
+		q.size--
+		if h.Len() == 0 {
+			q.removeHost(pos)
+		} else {
+			q.ringPos = (pos + 1) % len(q.hostRing)
+		}
+		return decodePriorityEnvelope(item.request), nil
+	}
+	return nil, nil
+}
+
+// removeHost drops an exhausted host from the ring, keeping ringPos
+// pointing at the next host to serve.
+func (q *PriorityQueueStorage) removeHost(pos int) {
+	host := q.hostRing[pos]
+	delete(q.hosts, host)
+	q.hostRing = append(q.hostRing[:pos], q.hostRing[pos+1:]...)
+	if len(q.hostRing) == 0 {
+		q.ringPos = 0
+		return
+	}
+	q.ringPos = pos % len(q.hostRing)
+}
+
+// QueueSize implements Storage.QueueSize.
+func (q *PriorityQueueStorage) QueueSize() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size, nil
+}
+
+// requestHost unmarshals just enough of a serialized request to read its
+// host for per-host scheduling.
+func requestHost(serialized []byte) (string, error) {
+	r := &colly.Request{}
+	if err := r.Unmarshal(serialized); err != nil {
+		return "", fmt.Errorf("queue: unable to read request host: %w", err)
+	}
+	if r.URL == nil {
+		return "", fmt.Errorf("queue: request has no URL")
+	}
+	return hostOf(r.URL), nil
+}
+
+func hostOf(u *url.URL) string {
+	if u.Host != "" {
+		return u.Host
+	}
+	return "_"
+}
+
+// AddRequestWithPriority enqueues r with priority on q's storage if the
+// storage backend implements PriorityStorage, and falls back to plain
+// AddRequest otherwise.
+func (q *Queue) AddRequestWithPriority(r *colly.Request, priority int) error {
+	d, err := r.Marshal()
+	if err != nil {
+		return err
+	}
+	size := int64(len(d))
+	if err := q.admit(size); err != nil {
+		return err
+	}
+
+	ps, ok := q.storage.(PriorityStorage)
+	if !ok {
+		if err := q.storage.AddRequest(d); err != nil {
+			q.release(size)
+			return err
+		}
+		q.signal()
+		return nil
+	}
+	if err := ps.AddRequestWithPriority(d, priority); err != nil {
+		q.release(size)
+		return err
+	}
+	q.signal()
+	return nil
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"container/heap"
+	"net/url"
+	"testing"
+)
+
+func TestEncodeDecodePriorityEnvelopeRoundTrip(t *testing.T) {
+	data := []byte("request bytes")
+	for _, priority := range []int{0, 1, -1, 127, -128} {
+		envelope := encodePriorityEnvelope(priority, data)
+		if len(envelope) != len(data)+1 {
+			t.Fatalf("priority %d: envelope length = %d, want %d", priority, len(envelope), len(data)+1)
+		}
+		if got := decodePriorityEnvelope(envelope); string(got) != string(data) {
+			t.Errorf("priority %d: decodePriorityEnvelope = %q, want %q", priority, got, data)
+		}
+	}
+}
+
+func TestEncodePriorityEnvelopeClamps(t *testing.T) {
+	data := []byte("x")
+	over := encodePriorityEnvelope(200, data)
+	under := encodePriorityEnvelope(-200, data)
+	if over[0] != byte(127+priorityByteBias) {
+		t.Errorf("priority 200 clamped byte = %d, want %d", over[0], byte(127+priorityByteBias))
+	}
+	if under[0] != byte(-128+priorityByteBias) {
+		t.Errorf("priority -200 clamped byte = %d, want %d", under[0], byte(-128+priorityByteBias))
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	withHost, _ := url.Parse("https://example.com/path")
+	noHost := &url.URL{Path: "/path"}
+
+	if got := hostOf(withHost); got != "example.com" {
+		t.Errorf("hostOf(%v) = %q, want %q", withHost, got, "example.com")
+	}
+	if got := hostOf(noHost); got != "_" {
+		t.Errorf("hostOf(%v) = %q, want %q", noHost, got, "_")
+	}
+}
+
+func TestHostHeapOrdering(t *testing.T) {
+	h := &hostHeap{}
+	heap.Init(h)
+
+	heap.Push(h, &priorityItem{request: []byte("low"), priority: 1, seq: 1})
+	heap.Push(h, &priorityItem{request: []byte("high"), priority: 5, seq: 2})
+	heap.Push(h, &priorityItem{request: []byte("tie-first"), priority: 5, seq: 0})
+
+	want := []string{"tie-first", "high", "low"}
+	for _, w := range want {
+		item := heap.Pop(h).(*priorityItem)
+		if string(item.request) != w {
+			t.Errorf("Pop = %q, want %q", item.request, w)
+		}
+	}
+}
+
+func TestPriorityQueueStoragePerHostRoundRobin(t *testing.T) {
+	q := &PriorityQueueStorage{}
+	if err := q.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	q.hosts["a"] = &hostHeap{}
+	heap.Init(q.hosts["a"])
+	q.hostRing = append(q.hostRing, "a")
+	heap.Push(q.hosts["a"], &priorityItem{request: encodePriorityEnvelope(0, []byte("a1")), seq: 1})
+	q.size++
+	heap.Push(q.hosts["a"], &priorityItem{request: encodePriorityEnvelope(0, []byte("a2")), seq: 2})
+	q.size++
+
+	q.hosts["b"] = &hostHeap{}
+	heap.Init(q.hosts["b"])
+	q.hostRing = append(q.hostRing, "b")
+	heap.Push(q.hosts["b"], &priorityItem{request: encodePriorityEnvelope(0, []byte("b1")), seq: 3})
+	q.size++
+
+	want := []string{"a1", "b1", "a2"}
+	for _, w := range want {
+		got, err := q.GetRequest()
+		if err != nil {
+			t.Fatalf("GetRequest: %v", err)
+		}
+		if string(got) != w {
+			t.Errorf("GetRequest = %q, want %q", got, w)
+		}
+	}
+
+	if size, _ := q.QueueSize(); size != 0 {
+		t.Errorf("QueueSize = %d, want 0", size)
+	}
+}
@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"context"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -23,6 +24,21 @@ type Storage interface {
 	QueueSize() (int, error)
 }
 
+// QueueOptions configures admission control for a Queue. The zero value
+// keeps the historical behavior: unbounded bytes/in-flight count and no
+// blocking.
+type QueueOptions struct {
+	// MaxBytes bounds the total serialized size, in bytes, of requests
+	// that are enqueued or being processed. Zero means unbounded.
+	MaxBytes int64
+	// MaxInFlight bounds the number of requests that are enqueued or
+	// being processed at once. Zero means unbounded.
+	MaxInFlight int
+	// BlockOnFull makes AddRequest/AddURL block until capacity frees up
+	// instead of returning ErrQueueFull.
+	BlockOnFull bool
+}
+
 // Queue is a request queue which uses a Collector to consume
 // requests in multiple threads
 type Queue struct {
@@ -30,7 +46,36 @@ type Queue struct {
 	Threads           int
 	storage           Storage
 	activeThreadCount int32
-	requestsOut       chan *colly.Request
+	requestsOut       chan *queuedRequest
+	opts              QueueOptions
+
+	admissionLock sync.Mutex
+	admissionFree *sync.Cond
+	bytesUsed     int64
+	countUsed     int
+
+	notify chan struct{}
+
+	threadsLock sync.Mutex
+	stopWorker  chan struct{}
+	collector   *colly.Collector
+	wg          *sync.WaitGroup
+	leaser      LeaseStorage
+
+	cancelLock   sync.Mutex
+	cancel       context.CancelFunc
+	requeueFront int32
+}
+
+// queuedRequest pairs a dequeued request with the byte size that was
+// admitted for it, so that size can be released once r.Do() returns. If
+// the request came from a LeaseStorage backend, leaseID/leased identify
+// the record to Ack once r.Do() succeeds.
+type queuedRequest struct {
+	req     *colly.Request
+	size    int64
+	leaseID uint64
+	leased  bool
 }
 
 // InMemoryQueueStorage is the default implementation of the Storage interface.
@@ -43,6 +88,7 @@ type InMemoryQueueStorage struct {
 	size    int
 	first   *inMemoryQueueItem
 	last    *inMemoryQueueItem
+	notify  chan struct{}
 }
 
 type inMemoryQueueItem struct {
@@ -53,17 +99,29 @@ type inMemoryQueueItem struct {
 // New creates a new queue with a Storage specified in argument
 // A standard InMemoryQueueStorage is used if Storage argument is nil.
 func New(threads int, s Storage) (*Queue, error) {
+	return NewWithOptions(threads, s, QueueOptions{})
+}
+
+// NewWithOptions creates a new queue the same way New does, but also
+// applies QueueOptions to bound how much serialized request data may be
+// enqueued or in flight at once.
+func NewWithOptions(threads int, s Storage, o QueueOptions) (*Queue, error) {
 	if s == nil {
 		s = &InMemoryQueueStorage{MaxSize: 100000}
 	}
 	if err := s.Init(); err != nil {
 		return nil, err
 	}
-	return &Queue{
+	q := &Queue{
 		Threads:     threads,
 		storage:     s,
-		requestsOut: make(chan *colly.Request),
-	}, nil
+		requestsOut: make(chan *queuedRequest),
+		opts:        o,
+		notify:      make(chan struct{}, 1),
+		stopWorker:  make(chan struct{}),
+	}
+	q.admissionFree = sync.NewCond(&q.admissionLock)
+	return q, nil
 }
 
 // IsEmpty returns true if the queue is empty
@@ -82,20 +140,79 @@ func (q *Queue) AddURL(URL string) error {
 		URL:    u,
 		Method: "GET",
 	}
-	d, err := r.Marshal()
-	if err != nil {
-		return err
-	}
-	return q.storage.AddRequest(d)
+	return q.AddRequest(r)
 }
 
-// AddRequest adds a new Request to the queue
+// AddRequest adds a new Request to the queue. If QueueOptions.MaxBytes or
+// QueueOptions.MaxInFlight is reached, AddRequest either blocks until
+// capacity frees up (QueueOptions.BlockOnFull) or returns ErrQueueFull.
 func (q *Queue) AddRequest(r *colly.Request) error {
 	d, err := r.Marshal()
 	if err != nil {
 		return err
 	}
-	return q.storage.AddRequest(d)
+	size := int64(len(d))
+	if err := q.admit(size); err != nil {
+		return err
+	}
+	if err := q.storage.AddRequest(d); err != nil {
+		q.release(size)
+		return err
+	}
+	q.signal()
+	return nil
+}
+
+// admit reserves size bytes and one slot against QueueOptions, blocking
+// or returning ErrQueueFull once MaxBytes or MaxInFlight is reached.
+func (q *Queue) admit(size int64) error {
+	if q.opts.MaxBytes <= 0 && q.opts.MaxInFlight <= 0 {
+		return nil
+	}
+
+	q.admissionLock.Lock()
+	defer q.admissionLock.Unlock()
+	for q.atCapacityLocked(size) {
+		if !q.opts.BlockOnFull {
+			return ErrQueueFull
+		}
+		q.admissionFree.Wait()
+	}
+	q.bytesUsed += size
+	q.countUsed++
+	return nil
+}
+
+func (q *Queue) atCapacityLocked(size int64) bool {
+	if q.opts.MaxBytes > 0 && q.bytesUsed+size > q.opts.MaxBytes {
+		return true
+	}
+	if q.opts.MaxInFlight > 0 && q.countUsed+1 > q.opts.MaxInFlight {
+		return true
+	}
+	return false
+}
+
+// release returns size bytes and one slot to the pool once a request has
+// finished processing (or failed to enqueue), waking any blocked callers.
+func (q *Queue) release(size int64) {
+	if q.opts.MaxBytes <= 0 && q.opts.MaxInFlight <= 0 {
+		return
+	}
+	q.admissionLock.Lock()
+	q.bytesUsed -= size
+	q.countUsed--
+	q.admissionLock.Unlock()
+	q.admissionFree.Broadcast()
+}
+
+// signal wakes the dispatcher if it is waiting for storage to become
+// non-empty.
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
 }
 
 // Size returns the size of the queue
@@ -103,37 +220,138 @@ func (q *Queue) Size() (int, error) {
 	return q.storage.QueueSize()
 }
 
-// Run starts consumer threads and calls the Collector
-// to perform requests. Run blocks while the queue has active requests
+// Recover restores previously persisted state and re-enqueues requests
+// that were leased but never acknowledged, if the storage backend
+// implements Recoverer (backends with a visibility timeout re-enqueue
+// only leases older than it). Call this before Run after a process restart.
+func (q *Queue) Recover() error {
+	if r, ok := q.storage.(Recoverer); ok {
+		return r.Recover()
+	}
+	return nil
+}
+
+// Checkpoint forces the storage backend to persist its state, if it
+// implements Checkpointer.
+func (q *Queue) Checkpoint() error {
+	if c, ok := q.storage.(Checkpointer); ok {
+		return c.Checkpoint()
+	}
+	return nil
+}
+
+// Close releases any resources held by the storage backend, if it
+// implements Closer.
+func (q *Queue) Close() error {
+	if c, ok := q.storage.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Run starts consumer threads and calls the Collector to perform
+// requests. Run blocks while the queue has active requests. It is
+// equivalent to RunContext with a context that's never canceled.
 func (q *Queue) Run(c *colly.Collector) error {
+	return q.RunContext(context.Background(), c)
+}
+
+// RunContext is like Run, but stops early if ctx is canceled or Stop is
+// called. Workers finish whichever request they're currently processing
+// and then exit; any request that was fetched from storage but not yet
+// handed to a worker is returned to storage so it isn't lost - appended
+// normally, or placed at the front if Stop was called with drain=true.
+func (q *Queue) RunContext(ctx context.Context, c *colly.Collector) error {
+	if q.Threads <= 0 {
+		return ErrAllWorkersDied
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	q.cancelLock.Lock()
+	q.cancel = cancel
+	q.cancelLock.Unlock()
+	defer cancel()
+
 	wg := &sync.WaitGroup{}
+	q.wg = wg
+	q.collector = c
+
+	leaser, leased := q.storage.(LeaseStorage)
+	if leased {
+		q.leaser = leaser
+	}
+	batcher, batched := q.storage.(BatchStorage)
+	notifier, hasNotifier := q.storage.(Notifier)
+
 	for i := 0; i < q.Threads; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			atomic.AddInt32(&q.activeThreadCount, 1)
-			for r := range q.requestsOut {
-				r.Do()
-			}
-			atomic.AddInt32(&q.activeThreadCount, -1)
-		}()
+		q.spawnWorker()
 	}
 
+	var dispatchErr error
 	wg.Add(1)
 	go func(c *colly.Collector, s Storage) {
 		defer wg.Done()
+		var batch [][]byte
 		for {
-			if q.IsEmpty() {
-				if q.activeThreadCount == 0 {
+			if ctx.Err() != nil {
+				q.requeue(batch)
+				q.finish()
+				return
+			}
+
+			if len(batch) == 0 && q.IsEmpty() {
+				if atomic.LoadInt32(&q.activeThreadCount) == 0 {
 					q.finish()
-					break
+					return
+				}
+				var notify <-chan struct{} = q.notify
+				if hasNotifier {
+					notify = notifier.Notify()
+				}
+				select {
+				case <-notify:
+				case <-ctx.Done():
 				}
 				continue
 			}
-			rb, err := s.GetRequest()
-			if err != nil || rb == nil {
-				//q.finish()
-				break
+
+			var rb []byte
+			var id uint64
+			var err error
+			var leasedItem bool
+			switch {
+			case len(batch) > 0:
+				rb, batch = batch[0], batch[1:]
+			case batched:
+				// Checked before leased: a backend that implements both
+				// (e.g. RedisStreamStorage) should be drained via the
+				// cheaper batched round trip rather than one GetLeasedRequest
+				// call per item. BatchGetRequest itself drains any message
+				// the reclaimer handed back after a dead consumer abandoned
+				// it, so preferring it here doesn't starve that reclaim
+				// path.
+				batch, err = batcher.BatchGetRequest(q.Threads)
+				if err != nil {
+					break
+				}
+				if len(batch) == 0 {
+					continue
+				}
+				rb, batch = batch[0], batch[1:]
+			case leased:
+				rb, id, err = leaser.GetLeasedRequest()
+				leasedItem = true
+			default:
+				rb, err = s.GetRequest()
+			}
+			if err != nil {
+				dispatchErr = err
+				q.finish()
+				return
+			}
+			if rb == nil {
+				q.finish()
+				return
 			}
 			t := make([]byte, len(rb))
 			copy(t, rb)
@@ -141,14 +359,145 @@ func (q *Queue) Run(c *colly.Collector) error {
 			if err != nil || r == nil {
 				continue
 			}
-			q.requestsOut <- r
+
+			select {
+			case q.requestsOut <- &queuedRequest{req: r, size: int64(len(t)), leaseID: id, leased: leasedItem}:
+			case <-ctx.Done():
+				q.requeueItem(t, id, leasedItem)
+				q.finish()
+				return
+			}
 		}
 	}(c, q.storage)
 
 	wg.Wait()
+
+	// Clear q.wg so SetThreads can tell this Run/RunContext has finished:
+	// without this, a SetThreads call after a completed Run still sees a
+	// non-nil wg, takes the "active" branch, and either calls Add on a
+	// WaitGroup whose Wait has already returned or spawns a worker
+	// reading from the now-closed requestsOut - both are misuse that can
+	// panic.
+	q.threadsLock.Lock()
+	q.wg = nil
+	q.threadsLock.Unlock()
+
+	if dispatchErr != nil {
+		return dispatchErr
+	}
+	if ctx.Err() != nil {
+		return ErrCanceled
+	}
 	return nil
 }
 
+// Stop asks a running RunContext to stop dispatching new requests. If
+// drain is true, a request that was already fetched from storage but not
+// yet handed to a worker is re-enqueued at the front of storage so it's
+// the next thing processed on a future Run; otherwise it's just appended
+// normally. If the queue isn't running, Stop cancels nothing but still
+// records drain, which then applies to the next RunContext.
+func (q *Queue) Stop(drain bool) {
+	if drain {
+		atomic.StoreInt32(&q.requeueFront, 1)
+	} else {
+		atomic.StoreInt32(&q.requeueFront, 0)
+	}
+	q.cancelLock.Lock()
+	cancel := q.cancel
+	q.cancelLock.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// requeueItem returns a single fetched-but-undispatched request to
+// storage on cancellation. If it was fetched via LeaseStorage, the
+// original leased record is Ack'd before the fresh copy is requeued -
+// otherwise that record would sit leased forever (a storage leak) and
+// eventually be revived by a later Recover() call, delivering the
+// request a second time alongside the copy requeue just added.
+func (q *Queue) requeueItem(item []byte, leaseID uint64, leased bool) {
+	if leased && q.leaser != nil {
+		q.leaser.Ack(leaseID)
+	}
+	q.requeue([][]byte{item})
+}
+
+// requeue returns fetched-but-undispatched requests to storage on
+// cancellation; see RunContext and Stop.
+func (q *Queue) requeue(items [][]byte) {
+	front := atomic.LoadInt32(&q.requeueFront) == 1
+	for _, item := range items {
+		if front {
+			if fs, ok := q.storage.(FrontStorage); ok {
+				fs.AddRequestFront(item)
+				continue
+			}
+		}
+		q.storage.AddRequest(item)
+	}
+}
+
+// SetThreads changes the number of consumer threads while Run is active.
+// Growing spawns additional workers immediately; shrinking asks the
+// excess workers to stop once they finish their current request. While
+// a Run/RunContext is active, n is clamped to at least 1: the dispatcher
+// has no way to un-send a request it has already handed to
+// requestsOut, so dropping to zero active workers while storage still
+// has items would leave that send blocked forever with nothing left to
+// receive it.
+func (q *Queue) SetThreads(n int) {
+	q.threadsLock.Lock()
+	defer q.threadsLock.Unlock()
+	if q.wg != nil && n < 1 {
+		n = 1
+	}
+	diff := n - q.Threads
+	q.Threads = n
+	if q.wg == nil {
+		// Run hasn't started yet; the new Threads value takes effect
+		// when it does.
+		return
+	}
+	if diff > 0 {
+		for i := 0; i < diff; i++ {
+			q.spawnWorker()
+		}
+	} else if diff < 0 {
+		for i := 0; i < -diff; i++ {
+			go func() { q.stopWorker <- struct{}{} }()
+		}
+	}
+}
+
+// spawnWorker starts a single consumer goroutine that pulls requests off
+// requestsOut until it is asked to stop or the channel is closed.
+func (q *Queue) spawnWorker() {
+	q.wg.Add(1)
+	atomic.AddInt32(&q.activeThreadCount, 1)
+	go func() {
+		defer q.wg.Done()
+		defer atomic.AddInt32(&q.activeThreadCount, -1)
+		for {
+			select {
+			case <-q.stopWorker:
+				return
+			case item, ok := <-q.requestsOut:
+				if !ok {
+					return
+				}
+				err := item.req.Do()
+				if item.leased && err == nil {
+					q.leaser.Ack(item.leaseID)
+				}
+				q.release(item.size)
+				q.signal()
+			}
+		}
+	}()
+}
+
 func (q *Queue) finish() {
 	close(q.requestsOut)
 }
@@ -156,15 +505,16 @@ func (q *Queue) finish() {
 // Init implements Storage.Init() function
 func (q *InMemoryQueueStorage) Init() error {
 	q.lock = &sync.RWMutex{}
+	q.notify = make(chan struct{}, 1)
 	return nil
 }
 
 // AddRequest implements Storage.AddRequest() function
 func (q *InMemoryQueueStorage) AddRequest(r []byte) error {
 	q.lock.Lock()
-	defer q.lock.Unlock()
 	// Discard URLs if size limit exceeded
 	if q.MaxSize > 0 && q.size >= q.MaxSize {
+		q.lock.Unlock()
 		return nil
 	}
 	i := &inMemoryQueueItem{Request: r}
@@ -175,9 +525,43 @@ func (q *InMemoryQueueStorage) AddRequest(r []byte) error {
 	}
 	q.last = i
 	q.size++
+	q.lock.Unlock()
+	q.signal()
 	return nil
 }
 
+// AddRequestFront implements FrontStorage.AddRequestFront, pushing r
+// onto the head of the queue instead of the tail.
+func (q *InMemoryQueueStorage) AddRequestFront(r []byte) error {
+	q.lock.Lock()
+	if q.MaxSize > 0 && q.size >= q.MaxSize {
+		q.lock.Unlock()
+		return nil
+	}
+	i := &inMemoryQueueItem{Request: r, Next: q.first}
+	q.first = i
+	if q.size == 0 {
+		q.last = i
+	}
+	q.size++
+	q.lock.Unlock()
+	q.signal()
+	return nil
+}
+
+// Notify implements Notifier.Notify, firing (a non-blocking send into a
+// one-slot buffer) every time AddRequest or AddRequestFront adds a request.
+func (q *InMemoryQueueStorage) Notify() <-chan struct{} {
+	return q.notify
+}
+
+func (q *InMemoryQueueStorage) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
 // GetRequest implements Storage.GetRequest() function
 func (q *InMemoryQueueStorage) GetRequest() ([]byte, error) {
 	q.lock.Lock()
@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryQueueStorageFIFO(t *testing.T) {
+	s := &InMemoryQueueStorage{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	for _, r := range []string{"a", "b", "c"} {
+		if err := s.AddRequest([]byte(r)); err != nil {
+			t.Fatalf("AddRequest(%q): %v", r, err)
+		}
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		got, err := s.GetRequest()
+		if err != nil {
+			t.Fatalf("GetRequest: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("GetRequest = %q, want %q", got, want)
+		}
+	}
+
+	if size, _ := s.QueueSize(); size != 0 {
+		t.Errorf("QueueSize = %d, want 0", size)
+	}
+}
+
+func TestInMemoryQueueStorageAddRequestFront(t *testing.T) {
+	s := &InMemoryQueueStorage{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	s.AddRequest([]byte("a"))
+	s.AddRequest([]byte("b"))
+	s.AddRequestFront([]byte("front"))
+
+	for _, want := range []string{"front", "a", "b"} {
+		got, err := s.GetRequest()
+		if err != nil {
+			t.Fatalf("GetRequest: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("GetRequest = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestInMemoryQueueStorageMaxSize(t *testing.T) {
+	s := &InMemoryQueueStorage{MaxSize: 1}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	s.AddRequest([]byte("a"))
+	s.AddRequest([]byte("b"))
+
+	if size, _ := s.QueueSize(); size != 1 {
+		t.Errorf("QueueSize = %d, want 1", size)
+	}
+}
+
+func TestInMemoryQueueStorageNotify(t *testing.T) {
+	s := &InMemoryQueueStorage{}
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	s.AddRequest([]byte("a"))
+
+	select {
+	case <-s.Notify():
+	default:
+		t.Fatal("Notify did not fire after AddRequest on an empty queue")
+	}
+}
+
+func TestQueueAdmitMaxBytes(t *testing.T) {
+	q, err := NewWithOptions(1, nil, QueueOptions{MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	if err := q.admit(6); err != nil {
+		t.Fatalf("admit(6): %v", err)
+	}
+	if err := q.admit(5); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("admit(5) = %v, want ErrQueueFull", err)
+	}
+
+	q.release(6)
+	if err := q.admit(5); err != nil {
+		t.Fatalf("admit(5) after release: %v", err)
+	}
+}
+
+func TestQueueAdmitMaxInFlight(t *testing.T) {
+	q, err := NewWithOptions(1, nil, QueueOptions{MaxInFlight: 1})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	if err := q.admit(1); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if err := q.admit(1); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("admit (2nd) = %v, want ErrQueueFull", err)
+	}
+
+	q.release(1)
+	if err := q.admit(1); err != nil {
+		t.Fatalf("admit after release: %v", err)
+	}
+}
+
+func TestQueueAdmitBlockOnFull(t *testing.T) {
+	q, err := NewWithOptions(1, nil, QueueOptions{MaxInFlight: 1, BlockOnFull: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	if err := q.admit(1); err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+
+	unblocked := make(chan error, 1)
+	go func() {
+		unblocked <- q.admit(1)
+	}()
+
+	select {
+	case <-unblocked:
+		t.Fatal("admit returned before capacity was released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.release(1)
+
+	select {
+	case err := <-unblocked:
+		if err != nil {
+			t.Fatalf("admit after release: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("admit stayed blocked after release")
+	}
+}
+
+func TestSetThreadsBeforeRunAllowsZero(t *testing.T) {
+	q, err := New(4, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	q.SetThreads(0)
+	if q.Threads != 0 {
+		t.Errorf("Threads = %d, want 0", q.Threads)
+	}
+}
+
+func TestSetThreadsClampsToOneWhileRunning(t *testing.T) {
+	q, err := New(1, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// Simulate RunContext having started, without actually spawning
+	// workers: Threads is already 1, so the clamp below can't change the
+	// worker count and won't try to spawn or signal stopWorker.
+	q.wg = &sync.WaitGroup{}
+
+	q.SetThreads(0)
+	if q.Threads != 1 {
+		t.Errorf("Threads = %d, want 1 (clamped while running)", q.Threads)
+	}
+}
@@ -0,0 +1,52 @@
+package queue
+
+import "testing"
+
+// fakeFrontStorage is a minimal Storage + FrontStorage double for
+// exercising requeue without a real backend.
+type fakeFrontStorage struct {
+	back  []string
+	front []string
+}
+
+func (s *fakeFrontStorage) Init() error                { return nil }
+func (s *fakeFrontStorage) AddRequest(r []byte) error   { s.back = append(s.back, string(r)); return nil }
+func (s *fakeFrontStorage) GetRequest() ([]byte, error) { return nil, nil }
+func (s *fakeFrontStorage) QueueSize() (int, error)     { return len(s.back) + len(s.front), nil }
+func (s *fakeFrontStorage) AddRequestFront(r []byte) error {
+	s.front = append(s.front, string(r))
+	return nil
+}
+
+func TestRequeueAppendsToBackByDefault(t *testing.T) {
+	fs := &fakeFrontStorage{}
+	q, err := New(1, fs)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	q.requeue([][]byte{[]byte("a")})
+
+	if len(fs.front) != 0 || len(fs.back) != 1 || fs.back[0] != "a" {
+		t.Errorf("requeue without Stop(drain) = front:%v back:%v, want back:[a]", fs.front, fs.back)
+	}
+}
+
+func TestRequeuePrependsAfterStopDrain(t *testing.T) {
+	fs := &fakeFrontStorage{}
+	q, err := New(1, fs)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Stop normally calls cancel(), which is only set once RunContext
+	// starts; here we only need the requeueFront flag it sets, so set it
+	// directly the way Stop(true) would.
+	q.Stop(true)
+
+	q.requeue([][]byte{[]byte("a")})
+
+	if len(fs.back) != 0 || len(fs.front) != 1 || fs.front[0] != "a" {
+		t.Errorf("requeue after Stop(true) = front:%v back:%v, want front:[a]", fs.front, fs.back)
+	}
+}
@@ -0,0 +1,231 @@
+// Package badger provides a BadgerDB-backed queue.Storage implementation
+// so a crawl's queue survives process restart.
+package badger
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	statusQueued byte = 0
+	statusLeased byte = 1
+)
+
+var keyPrefix = []byte("q:")
+
+// queuedIndexPrefix namespaces a secondary index of just the currently-
+// queued ids, so GetLeasedRequest can seek straight to the oldest queued
+// record instead of iterating past leased ones to find it.
+var queuedIndexPrefix = []byte("qi:")
+
+// Config configures a Storage.
+type Config struct {
+	// Dir is the BadgerDB directory to open or create.
+	Dir string
+}
+
+// Storage is a queue.Storage backed by a BadgerDB database. Records are
+// keyed by "q:" plus an 8-byte big-endian sequence number so the oldest
+// queued request always sorts first, and a one-byte status prefix on the
+// value marks whether a record is queued or leased to a worker. A
+// second "qi:"-prefixed index tracks just the currently-queued ids, so
+// GetLeasedRequest can seek straight to the oldest one instead of
+// iterating past leased records to find it.
+type Storage struct {
+	cfg Config
+	db  *badger.DB
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// New creates a Storage from cfg. Call Init before using it.
+func New(cfg Config) *Storage {
+	return &Storage{cfg: cfg}
+}
+
+// Init implements queue.Storage.Init.
+func (s *Storage) Init() error {
+	db, err := badger.Open(badger.DefaultOptions(s.cfg.Dir))
+	if err != nil {
+		return fmt.Errorf("badger: open %s: %w", s.cfg.Dir, err)
+	}
+	s.db = db
+
+	return db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		seekKey := append(append([]byte(nil), keyPrefix...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+		it.Seek(seekKey)
+		if it.ValidForPrefix(keyPrefix) {
+			s.seq = binary.BigEndian.Uint64(it.Item().Key()[len(keyPrefix):])
+		}
+		return nil
+	})
+}
+
+// AddRequest implements queue.Storage.AddRequest.
+func (s *Storage) AddRequest(r []byte) error {
+	s.mu.Lock()
+	s.seq++
+	id := s.seq
+	s.mu.Unlock()
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(encodeKey(id), encodeValue(statusQueued, r)); err != nil {
+			return err
+		}
+		return txn.Set(encodeQueuedIndexKey(id), nil)
+	})
+}
+
+// GetRequest implements queue.Storage.GetRequest, discarding the lease
+// id returned by GetLeasedRequest for callers that don't Ack.
+func (s *Storage) GetRequest() ([]byte, error) {
+	r, _, err := s.GetLeasedRequest()
+	return r, err
+}
+
+// GetLeasedRequest implements queue.LeaseStorage.GetLeasedRequest: it
+// takes the oldest id from the queued index and marks the corresponding
+// record leased instead of deleting it, so Recover can redeliver it if
+// the worker that popped it dies before calling Ack.
+func (s *Storage) GetLeasedRequest() ([]byte, uint64, error) {
+	var id uint64
+	var data []byte
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = queuedIndexPrefix
+		it := txn.NewIterator(opts)
+		it.Seek(queuedIndexPrefix)
+		valid := it.ValidForPrefix(queuedIndexPrefix)
+		var indexKey []byte
+		if valid {
+			id = binary.BigEndian.Uint64(it.Item().Key()[len(queuedIndexPrefix):])
+			indexKey = it.Item().KeyCopy(nil)
+		}
+		it.Close()
+		if !valid {
+			return nil
+		}
+
+		key := encodeKey(id)
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		data = append([]byte(nil), v[1:]...)
+		if err := txn.Set(key, encodeValue(statusLeased, data)); err != nil {
+			return err
+		}
+		return txn.Delete(indexKey)
+	})
+	if err != nil || data == nil {
+		return nil, 0, err
+	}
+	return data, id, nil
+}
+
+// Ack implements queue.LeaseStorage.Ack, permanently removing the
+// leased record.
+func (s *Storage) Ack(id uint64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(encodeKey(id))
+	})
+}
+
+// QueueSize implements queue.Storage.QueueSize, counting only records
+// that are still queued, not leased.
+func (s *Storage) QueueSize() (int, error) {
+	var n int
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = keyPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if v[0] == statusQueued {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Checkpoint implements queue.Checkpointer by flushing Badger's value
+// log and LSM tree to disk.
+func (s *Storage) Checkpoint() error {
+	return s.db.Sync()
+}
+
+// Recover implements queue.Recoverer, flipping every leased record back
+// to queued so it is redelivered. It's meant to be called once on
+// startup, before any workers are leasing requests.
+func (s *Storage) Recover() error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = keyPrefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if v[0] != statusLeased {
+				continue
+			}
+			if err := txn.Set(item.KeyCopy(nil), encodeValue(statusQueued, v[1:])); err != nil {
+				return err
+			}
+			id := binary.BigEndian.Uint64(item.Key()[len(keyPrefix):])
+			if err := txn.Set(encodeQueuedIndexKey(id), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close implements queue.Closer.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func encodeKey(id uint64) []byte {
+	k := make([]byte, len(keyPrefix)+8)
+	copy(k, keyPrefix)
+	binary.BigEndian.PutUint64(k[len(keyPrefix):], id)
+	return k
+}
+
+func encodeQueuedIndexKey(id uint64) []byte {
+	k := make([]byte, len(queuedIndexPrefix)+8)
+	copy(k, queuedIndexPrefix)
+	binary.BigEndian.PutUint64(k[len(queuedIndexPrefix):], id)
+	return k
+}
+
+func encodeValue(status byte, data []byte) []byte {
+	v := make([]byte, 1+len(data))
+	v[0] = status
+	copy(v[1:], data)
+	return v
+}
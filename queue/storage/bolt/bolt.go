@@ -0,0 +1,199 @@
+// Package bolt provides a BoltDB-backed queue.Storage implementation so
+// a crawl's queue survives process restart.
+package bolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	statusQueued byte = 0
+	statusLeased byte = 1
+)
+
+var defaultBucket = []byte("colly_queue")
+
+// queuedSuffix names the secondary bucket that indexes just the queued
+// (not leased) keys, keyed alongside the main bucket.
+const queuedSuffix = ".queued"
+
+// Config configures a Storage.
+type Config struct {
+	// Path is the BoltDB file to open or create.
+	Path string
+	// Bucket names the bucket requests are stored under. Defaults to
+	// "colly_queue" if empty.
+	Bucket string
+}
+
+// Storage is a queue.Storage backed by a BoltDB file. Records are keyed
+// by an 8-byte big-endian sequence number so GetLeasedRequest always
+// pops the oldest queued request, and a one-byte status prefix marks
+// whether a record is queued or leased to a worker. A second bucket
+// indexes only the currently-queued keys, so GetLeasedRequest can take
+// the oldest queued key directly instead of scanning past leased
+// records to find it.
+type Storage struct {
+	cfg         Config
+	db          *bbolt.DB
+	bucket      []byte
+	queuedIndex []byte
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// New creates a Storage from cfg. Call Init before using it.
+func New(cfg Config) *Storage {
+	bucket := []byte(cfg.Bucket)
+	if len(bucket) == 0 {
+		bucket = defaultBucket
+	}
+	return &Storage{cfg: cfg, bucket: bucket, queuedIndex: append(append([]byte{}, bucket...), queuedSuffix...)}
+}
+
+// Init implements queue.Storage.Init.
+func (s *Storage) Init() error {
+	db, err := bbolt.Open(s.cfg.Path, 0o600, nil)
+	if err != nil {
+		return fmt.Errorf("bolt: open %s: %w", s.cfg.Path, err)
+	}
+	s.db = db
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(s.queuedIndex); err != nil {
+			return err
+		}
+		k, _ := b.Cursor().Last()
+		if k != nil {
+			s.seq = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+}
+
+// AddRequest implements queue.Storage.AddRequest.
+func (s *Storage) AddRequest(r []byte) error {
+	s.mu.Lock()
+	s.seq++
+	id := s.seq
+	s.mu.Unlock()
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		key := encodeKey(id)
+		if err := tx.Bucket(s.bucket).Put(key, encodeValue(statusQueued, r)); err != nil {
+			return err
+		}
+		return tx.Bucket(s.queuedIndex).Put(key, nil)
+	})
+}
+
+// GetRequest implements queue.Storage.GetRequest, discarding the lease
+// id returned by GetLeasedRequest for callers that don't Ack.
+func (s *Storage) GetRequest() ([]byte, error) {
+	r, _, err := s.GetLeasedRequest()
+	return r, err
+}
+
+// GetLeasedRequest implements queue.LeaseStorage.GetLeasedRequest: it
+// takes the oldest key from the queued index and marks the corresponding
+// record leased instead of deleting it, so Recover can redeliver it if
+// the worker that popped it dies before calling Ack.
+func (s *Storage) GetLeasedRequest() ([]byte, uint64, error) {
+	var id uint64
+	var data []byte
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		qi := tx.Bucket(s.queuedIndex)
+		k, _ := qi.Cursor().First()
+		if k == nil {
+			return nil
+		}
+		b := tx.Bucket(s.bucket)
+		v := b.Get(k)
+		id = binary.BigEndian.Uint64(k)
+		data = append([]byte(nil), v[1:]...)
+		if err := b.Put(k, encodeValue(statusLeased, data)); err != nil {
+			return err
+		}
+		return qi.Delete(k)
+	})
+	if err != nil || data == nil {
+		return nil, 0, err
+	}
+	return data, id, nil
+}
+
+// Ack implements queue.LeaseStorage.Ack, permanently removing the
+// leased record.
+func (s *Storage) Ack(id uint64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete(encodeKey(id))
+	})
+}
+
+// QueueSize implements queue.Storage.QueueSize, counting only records
+// that are still queued, not leased.
+func (s *Storage) QueueSize() (int, error) {
+	var n int
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(_, v []byte) error {
+			if v[0] == statusQueued {
+				n++
+			}
+			return nil
+		})
+	})
+	return n, err
+}
+
+// Checkpoint implements queue.Checkpointer by forcing BoltDB's mmap'd
+// file to sync to disk.
+func (s *Storage) Checkpoint() error {
+	return s.db.Sync()
+}
+
+// Recover implements queue.Recoverer, flipping every leased record back
+// to queued so it is redelivered. It's meant to be called once on
+// startup, before any workers are leasing requests.
+func (s *Storage) Recover() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		qi := tx.Bucket(s.queuedIndex)
+		return b.ForEach(func(k, v []byte) error {
+			if v[0] != statusLeased {
+				return nil
+			}
+			if err := b.Put(k, encodeValue(statusQueued, v[1:])); err != nil {
+				return err
+			}
+			return qi.Put(k, nil)
+		})
+	})
+}
+
+// Close implements queue.Closer.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+func encodeKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+func encodeValue(status byte, data []byte) []byte {
+	v := make([]byte, 1+len(data))
+	v[0] = status
+	copy(v[1:], data)
+	return v
+}
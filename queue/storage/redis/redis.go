@@ -0,0 +1,188 @@
+// Package redis provides a Redis-backed queue.Storage implementation so
+// a crawl's queue survives process restart and can be shared by
+// multiple colly processes.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Config configures a Storage.
+type Config struct {
+	// Addr is the Redis server address, e.g. "localhost:6379".
+	Addr string
+	// Password authenticates with the Redis server, if set.
+	Password string
+	// DB selects the Redis logical database.
+	DB int
+	// KeyPrefix namespaces all keys this Storage uses. Defaults to
+	// "colly:queue" if empty.
+	KeyPrefix string
+	// VisibilityTimeout is how long a leased request is hidden from
+	// Recover before it's considered abandoned and redelivered.
+	// Defaults to 30s.
+	VisibilityTimeout time.Duration
+}
+
+// leaseScript atomically moves the oldest pending id into the leased
+// sorted set, so a crash between the pop and the lease record can never
+// happen - GetLeasedRequest either moves an id fully into leased or
+// doesn't touch it at all. It returns the id, or false if pending was
+// empty.
+var leaseScript = goredis.NewScript(`
+local id = redis.call('LPOP', KEYS[1])
+if not id then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[1], id)
+return id
+`)
+
+// Storage is a queue.Storage backed by Redis. Queued request ids live in
+// a list (for FIFO ordering), leased ids live in a sorted set scored by
+// lease deadline (so Recover can find expired leases cheaply), and
+// request bytes live in a hash keyed by id.
+type Storage struct {
+	cfg    Config
+	client *goredis.Client
+	ctx    context.Context
+
+	pending string
+	leased  string
+	data    string
+	seqKey  string
+}
+
+// New creates a Storage from cfg. Call Init before using it.
+func New(cfg Config) *Storage {
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "colly:queue"
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = 30 * time.Second
+	}
+	return &Storage{
+		cfg:     cfg,
+		ctx:     context.Background(),
+		pending: cfg.KeyPrefix + ":pending",
+		leased:  cfg.KeyPrefix + ":leased",
+		data:    cfg.KeyPrefix + ":data",
+		seqKey:  cfg.KeyPrefix + ":seq",
+	}
+}
+
+// Init implements queue.Storage.Init.
+func (s *Storage) Init() error {
+	s.client = goredis.NewClient(&goredis.Options{
+		Addr:     s.cfg.Addr,
+		Password: s.cfg.Password,
+		DB:       s.cfg.DB,
+	})
+	return s.client.Ping(s.ctx).Err()
+}
+
+// AddRequest implements queue.Storage.AddRequest.
+func (s *Storage) AddRequest(r []byte) error {
+	id, err := s.client.Incr(s.ctx, s.seqKey).Result()
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(s.ctx, s.data, id, r).Err(); err != nil {
+		return err
+	}
+	return s.client.RPush(s.ctx, s.pending, id).Err()
+}
+
+// GetRequest implements queue.Storage.GetRequest, discarding the lease
+// id returned by GetLeasedRequest for callers that don't Ack.
+func (s *Storage) GetRequest() ([]byte, error) {
+	r, _, err := s.GetLeasedRequest()
+	return r, err
+}
+
+// GetLeasedRequest implements queue.LeaseStorage.GetLeasedRequest: it
+// atomically moves the oldest pending id into the leased sorted set with
+// a visibility deadline, so Recover can redeliver it if the worker that
+// popped it dies before calling Ack. The move and the pop happen in a
+// single Lua script (leaseScript) so a crash can't leave an id removed
+// from pending without yet being recorded as leased.
+func (s *Storage) GetLeasedRequest() ([]byte, uint64, error) {
+	deadline := float64(time.Now().Add(s.cfg.VisibilityTimeout).UnixNano())
+	res, err := leaseScript.Run(s.ctx, s.client, []string{s.pending, s.leased}, deadline).Result()
+	if err == goredis.Nil {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	idStr, ok := res.(string)
+	if !ok {
+		// Any non-string reply is treated as an empty pending list; the
+		// script's false reply for an empty list arrives as goredis.Nil
+		// and is handled above.
+		return nil, 0, nil
+	}
+
+	data, err := s.client.HGet(s.ctx, s.data, idStr).Bytes()
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis: missing data for id %s: %w", idStr, err)
+	}
+
+	var id uint64
+	fmt.Sscan(idStr, &id)
+	return data, id, nil
+}
+
+// Ack implements queue.LeaseStorage.Ack, removing the lease and the
+// request's data.
+func (s *Storage) Ack(id uint64) error {
+	idStr := fmt.Sprint(id)
+	if err := s.client.ZRem(s.ctx, s.leased, idStr).Err(); err != nil {
+		return err
+	}
+	return s.client.HDel(s.ctx, s.data, idStr).Err()
+}
+
+// QueueSize implements queue.Storage.QueueSize, counting only pending
+// (not leased) requests.
+func (s *Storage) QueueSize() (int, error) {
+	n, err := s.client.LLen(s.ctx, s.pending).Result()
+	return int(n), err
+}
+
+// Checkpoint implements queue.Checkpointer by issuing a Redis BGSAVE.
+func (s *Storage) Checkpoint() error {
+	return s.client.BgSave(s.ctx).Err()
+}
+
+// Recover implements queue.Recoverer: any lease past its visibility
+// deadline is treated as abandoned and its id is pushed back onto the
+// pending list.
+func (s *Storage) Recover() error {
+	now := float64(time.Now().UnixNano())
+	expired, err := s.client.ZRangeByScore(s.ctx, s.leased, &goredis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(now),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, idStr := range expired {
+		if err := s.client.RPush(s.ctx, s.pending, idStr).Err(); err != nil {
+			return err
+		}
+		if err := s.client.ZRem(s.ctx, s.leased, idStr).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements queue.Closer.
+func (s *Storage) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// newTestStorage requires a live Redis instance; set REDIS_TEST_ADDR (e.g.
+// "localhost:6379") to run these tests. They're skipped otherwise since
+// this package has no in-process fake for the Redis protocol.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set; skipping test that needs a live Redis instance")
+	}
+	s := New(Config{Addr: addr, KeyPrefix: fmt.Sprintf("colly:queue:test:%d", os.Getpid())})
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		s.client.Del(s.ctx, s.pending, s.leased, s.data, s.seqKey)
+		s.Close()
+	})
+	return s
+}
+
+func TestGetLeasedRequestThenAckRemovesRecord(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+
+	data, id, err := s.GetLeasedRequest()
+	if err != nil {
+		t.Fatalf("GetLeasedRequest: %v", err)
+	}
+	if string(data) != "a" {
+		t.Fatalf("GetLeasedRequest data = %q, want %q", data, "a")
+	}
+
+	if n, _ := s.QueueSize(); n != 0 {
+		t.Errorf("QueueSize while leased = %d, want 0", n)
+	}
+
+	if err := s.Ack(id); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	if got, _, err := s.GetLeasedRequest(); err != nil || got != nil {
+		t.Errorf("GetLeasedRequest after Ack = (%v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestRecoverRedeliversExpiredLease(t *testing.T) {
+	s := newTestStorage(t)
+	s.cfg.VisibilityTimeout = 0
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+	if _, _, err := s.GetLeasedRequest(); err != nil {
+		t.Fatalf("GetLeasedRequest: %v", err)
+	}
+
+	if err := s.Recover(); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+
+	data, _, err := s.GetLeasedRequest()
+	if err != nil {
+		t.Fatalf("GetLeasedRequest after Recover: %v", err)
+	}
+	if string(data) != "a" {
+		t.Errorf("GetLeasedRequest after Recover = %q, want %q", data, "a")
+	}
+}
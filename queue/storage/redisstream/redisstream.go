@@ -0,0 +1,317 @@
+// Package redisstream provides a queue.Storage implementation backed by
+// a Redis Stream and consumer group, so multiple colly processes can
+// cooperatively drain one logical queue.
+package redisstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const dataField = "data"
+
+// Config configures a Storage.
+type Config struct {
+	Addr     string
+	Stream   string
+	Group    string
+	Consumer string
+	// VisibilityTimeout is how long a message may sit unacknowledged
+	// before the reclaimer goroutine claims it for redelivery.
+	VisibilityTimeout time.Duration
+	// BatchSize bounds how many messages BatchGetRequest and the
+	// reclaimer each fetch per round trip. Defaults to 16.
+	BatchSize int
+	// ReclaimInterval is how often the reclaimer goroutine scans for
+	// abandoned messages. Defaults to VisibilityTimeout / 2.
+	ReclaimInterval time.Duration
+}
+
+// Storage is a queue.Storage that reads and writes a Redis Stream
+// through a consumer group, so several colly processes can drain it in
+// parallel, each message going to one consumer at a time. GetLeasedRequest
+// and Ack give at-least-once delivery: a message stays pending until Ack,
+// and the reclaimer redelivers one left unacknowledged past
+// VisibilityTimeout. BatchGetRequest gives up that guarantee for fewer
+// round trips by acknowledging its whole batch immediately (see its doc
+// comment).
+type Storage struct {
+	cfg    Config
+	client *goredis.Client
+	ctx    context.Context
+
+	nextID    uint64
+	pending   sync.Map // uint64 lease id -> redis stream message id (string)
+	reclaimed chan goredis.XMessage
+
+	stopReclaim chan struct{}
+	reclaimDone chan struct{}
+}
+
+// New creates a Storage from cfg. Call Init before using it.
+func New(cfg Config) *Storage {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 16
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = 30 * time.Second
+	}
+	if cfg.ReclaimInterval <= 0 {
+		cfg.ReclaimInterval = cfg.VisibilityTimeout / 2
+	}
+	return &Storage{
+		cfg:         cfg,
+		ctx:         context.Background(),
+		reclaimed:   make(chan goredis.XMessage, cfg.BatchSize),
+		stopReclaim: make(chan struct{}),
+		reclaimDone: make(chan struct{}),
+	}
+}
+
+// Init implements queue.Storage.Init: it connects to Redis, creates the
+// stream and consumer group if they don't already exist, and starts the
+// reclaimer goroutine.
+func (s *Storage) Init() error {
+	s.client = goredis.NewClient(&goredis.Options{Addr: s.cfg.Addr})
+	if err := s.client.Ping(s.ctx).Err(); err != nil {
+		return err
+	}
+
+	err := s.client.XGroupCreateMkStream(s.ctx, s.cfg.Stream, s.cfg.Group, "0").Err()
+	if err != nil && !isBusyGroupErr(err) {
+		return fmt.Errorf("redisstream: create group: %w", err)
+	}
+
+	go s.reclaimLoop()
+	return nil
+}
+
+// AddRequest implements queue.Storage.AddRequest.
+func (s *Storage) AddRequest(r []byte) error {
+	return s.client.XAdd(s.ctx, &goredis.XAddArgs{
+		Stream: s.cfg.Stream,
+		Values: map[string]interface{}{dataField: r},
+	}).Err()
+}
+
+// GetRequest implements queue.Storage.GetRequest, discarding the lease
+// id returned by GetLeasedRequest for callers that don't Ack.
+func (s *Storage) GetRequest() ([]byte, error) {
+	r, _, err := s.GetLeasedRequest()
+	return r, err
+}
+
+// GetLeasedRequest implements queue.LeaseStorage.GetLeasedRequest. It
+// first drains any message the reclaimer recovered from a dead
+// consumer, then reads one new message for this consumer via
+// XREADGROUP. Either way the message stays in the group's pending
+// entries list (PEL) until Ack calls XACK.
+func (s *Storage) GetLeasedRequest() ([]byte, uint64, error) {
+	select {
+	case msg := <-s.reclaimed:
+		return s.lease(msg)
+	default:
+	}
+
+	streams, err := s.client.XReadGroup(s.ctx, &goredis.XReadGroupArgs{
+		Group:    s.cfg.Group,
+		Consumer: s.cfg.Consumer,
+		Streams:  []string{s.cfg.Stream, ">"},
+		Count:    1,
+		Block:    100 * time.Millisecond,
+	}).Result()
+	if err == goredis.Nil {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(streams) == 0 || len(streams[0].Messages) == 0 {
+		return nil, 0, nil
+	}
+	return s.lease(streams[0].Messages[0])
+}
+
+// lease assigns msg a local uint64 lease id, remembering the Redis
+// message id it maps to so Ack can find it again.
+func (s *Storage) lease(msg goredis.XMessage) ([]byte, uint64, error) {
+	data, err := messageData(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := atomic.AddUint64(&s.nextID, 1)
+	s.pending.Store(id, msg.ID)
+	return data, id, nil
+}
+
+// BatchGetRequest implements queue.BatchStorage.BatchGetRequest. Unlike
+// GetLeasedRequest, it acknowledges every message it returns immediately
+// via XACK, since Queue.Run has no way to Ack individual items out of a
+// batch. Use this only where a dispatcher round trip matters more than
+// surviving a worker crash mid-batch.
+//
+// Queue.Run prefers BatchGetRequest over GetLeasedRequest when a backend
+// implements both, so under Queue.Run reclaimed messages are served
+// here. BatchGetRequest drains s.reclaimed before reading new messages,
+// so a caller that only ever calls BatchGetRequest can't starve the
+// reclaimer (which would otherwise block forever trying to hand off a
+// message once s.reclaimed fills up).
+func (s *Storage) BatchGetRequest(n int) ([][]byte, error) {
+	if n > s.cfg.BatchSize {
+		n = s.cfg.BatchSize
+	}
+
+	out := make([][]byte, 0, n)
+	ackIDs := make([]string, 0, n)
+drainReclaimed:
+	for len(out) < n {
+		select {
+		case msg := <-s.reclaimed:
+			data, err := messageData(msg)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data)
+			ackIDs = append(ackIDs, msg.ID)
+		default:
+			break drainReclaimed
+		}
+	}
+	remaining := n - len(out)
+
+	if remaining > 0 {
+		streams, err := s.client.XReadGroup(s.ctx, &goredis.XReadGroupArgs{
+			Group:    s.cfg.Group,
+			Consumer: s.cfg.Consumer,
+			Streams:  []string{s.cfg.Stream, ">"},
+			Count:    int64(remaining),
+			Block:    100 * time.Millisecond,
+		}).Result()
+		if err != nil && err != goredis.Nil {
+			return nil, err
+		}
+		if len(streams) > 0 {
+			for _, msg := range streams[0].Messages {
+				data, err := messageData(msg)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, data)
+				ackIDs = append(ackIDs, msg.ID)
+			}
+		}
+	}
+
+	if len(ackIDs) == 0 {
+		return nil, nil
+	}
+	if err := s.client.XAck(s.ctx, s.cfg.Stream, s.cfg.Group, ackIDs...).Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Ack implements queue.LeaseStorage.Ack, issuing XACK for the Redis
+// message the given lease id maps to.
+func (s *Storage) Ack(id uint64) error {
+	v, ok := s.pending.LoadAndDelete(id)
+	if !ok {
+		return fmt.Errorf("redisstream: unknown lease id %d", id)
+	}
+	return s.client.XAck(s.ctx, s.cfg.Stream, s.cfg.Group, v.(string)).Err()
+}
+
+// QueueSize implements queue.Storage.QueueSize, reporting the stream's
+// total length. XACK doesn't remove entries from a stream, so messages
+// already delivered and acknowledged are counted along with the rest.
+func (s *Storage) QueueSize() (int, error) {
+	n, err := s.client.XLen(s.ctx, s.cfg.Stream).Result()
+	return int(n), err
+}
+
+// Checkpoint implements queue.Checkpointer by issuing a Redis BGSAVE.
+func (s *Storage) Checkpoint() error {
+	return s.client.BgSave(s.ctx).Err()
+}
+
+// Recover implements queue.Recoverer. Redis Streams already persist the
+// group's pending entries list across restarts, so recovery is just an
+// immediate reclaim pass instead of a full rescan.
+func (s *Storage) Recover() error {
+	return s.reclaimOnce()
+}
+
+// Close implements queue.Closer: it stops the reclaimer goroutine and
+// closes the Redis client.
+func (s *Storage) Close() error {
+	close(s.stopReclaim)
+	<-s.reclaimDone
+	return s.client.Close()
+}
+
+// reclaimLoop periodically claims messages that have been pending longer
+// than VisibilityTimeout, which means the consumer that read them died
+// (or is still working) without acking in time.
+func (s *Storage) reclaimLoop() {
+	defer close(s.reclaimDone)
+	ticker := time.NewTicker(s.cfg.ReclaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopReclaim:
+			return
+		case <-ticker.C:
+			s.reclaimOnce()
+		}
+	}
+}
+
+// reclaimOnce runs one XAUTOCLAIM pass, handing any reclaimed messages
+// to GetLeasedRequest via the reclaimed channel.
+func (s *Storage) reclaimOnce() error {
+	cursor := "0-0"
+	for {
+		msgs, next, err := s.client.XAutoClaim(s.ctx, &goredis.XAutoClaimArgs{
+			Stream:   s.cfg.Stream,
+			Group:    s.cfg.Group,
+			Consumer: s.cfg.Consumer,
+			MinIdle:  s.cfg.VisibilityTimeout,
+			Start:    cursor,
+			Count:    int64(s.cfg.BatchSize),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			s.reclaimed <- msg
+		}
+		if next == "0-0" || len(msgs) == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func messageData(msg goredis.XMessage) ([]byte, error) {
+	v, ok := msg.Values[dataField]
+	if !ok {
+		return nil, fmt.Errorf("redisstream: message %s missing %q field", msg.ID, dataField)
+	}
+	switch data := v.(type) {
+	case string:
+		return []byte(data), nil
+	case []byte:
+		return data, nil
+	default:
+		return nil, fmt.Errorf("redisstream: message %s has unexpected %q type %T", msg.ID, dataField, v)
+	}
+}
+
+func isBusyGroupErr(err error) bool {
+	return err != nil && len(err.Error()) >= 9 && err.Error()[:9] == "BUSYGROUP"
+}
@@ -0,0 +1,128 @@
+package redisstream
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// newTestStorage requires a live Redis instance; set REDIS_TEST_ADDR (e.g.
+// "localhost:6379") to run these tests. They're skipped otherwise since
+// this package has no in-process fake for Redis Streams/consumer groups.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set; skipping test that needs a live Redis instance")
+	}
+	stream := fmt.Sprintf("colly:stream:test:%d", os.Getpid())
+	s := New(Config{
+		Addr:              addr,
+		Stream:            stream,
+		Group:             "test-group",
+		Consumer:          "test-consumer",
+		VisibilityTimeout: 50 * time.Millisecond,
+		ReclaimInterval:   10 * time.Millisecond,
+	})
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		s.client.Del(s.ctx, stream)
+		s.Close()
+	})
+	return s
+}
+
+func TestGetLeasedRequestThenAckRemovesRecord(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+
+	data, id, err := s.GetLeasedRequest()
+	if err != nil {
+		t.Fatalf("GetLeasedRequest: %v", err)
+	}
+	if string(data) != "a" {
+		t.Fatalf("GetLeasedRequest data = %q, want %q", data, "a")
+	}
+
+	if err := s.Ack(id); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	pending, err := s.client.XPending(s.ctx, s.cfg.Stream, s.cfg.Group).Result()
+	if err != nil {
+		t.Fatalf("XPending: %v", err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("pending count after Ack = %d, want 0", pending.Count)
+	}
+}
+
+func TestReclaimRedeliversAbandonedMessage(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+	// Read it as a different consumer so it's left pending without this
+	// Storage's bookkeeping knowing about it - simulating a dead worker.
+	_, err := s.client.XReadGroup(s.ctx, &goredis.XReadGroupArgs{
+		Group:    s.cfg.Group,
+		Consumer: "dead-consumer",
+		Streams:  []string{s.cfg.Stream, ">"},
+		Count:    1,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XReadGroup: %v", err)
+	}
+
+	time.Sleep(s.cfg.VisibilityTimeout * 2)
+	if err := s.reclaimOnce(); err != nil {
+		t.Fatalf("reclaimOnce: %v", err)
+	}
+
+	data, _, err := s.GetLeasedRequest()
+	if err != nil {
+		t.Fatalf("GetLeasedRequest: %v", err)
+	}
+	if string(data) != "a" {
+		t.Errorf("GetLeasedRequest after reclaim = %q, want %q", data, "a")
+	}
+}
+
+func TestBatchGetRequestDrainsReclaimedFirst(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+	_, err := s.client.XReadGroup(s.ctx, &goredis.XReadGroupArgs{
+		Group:    s.cfg.Group,
+		Consumer: "dead-consumer",
+		Streams:  []string{s.cfg.Stream, ">"},
+		Count:    1,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XReadGroup: %v", err)
+	}
+
+	time.Sleep(s.cfg.VisibilityTimeout * 2)
+	if err := s.reclaimOnce(); err != nil {
+		t.Fatalf("reclaimOnce: %v", err)
+	}
+
+	batch, err := s.BatchGetRequest(1)
+	if err != nil {
+		t.Fatalf("BatchGetRequest: %v", err)
+	}
+	if len(batch) != 1 || string(batch[0]) != "a" {
+		t.Errorf("BatchGetRequest = %v, want [[]byte(\"a\")]", batch)
+	}
+}
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s := New(Config{Path: filepath.Join(t.TempDir(), "queue.db")})
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestGetLeasedRequestThenAckRemovesRecord(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+
+	data, id, err := s.GetLeasedRequest()
+	if err != nil {
+		t.Fatalf("GetLeasedRequest: %v", err)
+	}
+	if string(data) != "a" {
+		t.Fatalf("GetLeasedRequest data = %q, want %q", data, "a")
+	}
+
+	if n, _ := s.QueueSize(); n != 0 {
+		t.Errorf("QueueSize while leased = %d, want 0", n)
+	}
+
+	if err := s.Ack(id); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	if got, _, err := s.GetLeasedRequest(); err != nil || got != nil {
+		t.Errorf("GetLeasedRequest after Ack = (%v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestRecoverRedeliversUnackedLease(t *testing.T) {
+	s := newTestStorage(t)
+
+	if err := s.AddRequest([]byte("a")); err != nil {
+		t.Fatalf("AddRequest: %v", err)
+	}
+	if _, _, err := s.GetLeasedRequest(); err != nil {
+		t.Fatalf("GetLeasedRequest: %v", err)
+	}
+
+	if err := s.Recover(); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+
+	data, _, err := s.GetLeasedRequest()
+	if err != nil {
+		t.Fatalf("GetLeasedRequest after Recover: %v", err)
+	}
+	if string(data) != "a" {
+		t.Errorf("GetLeasedRequest after Recover = %q, want %q", data, "a")
+	}
+}
+
+func TestInitRejectsInvalidTableName(t *testing.T) {
+	for _, bad := range []string{"1abc", "abc;drop table x", "abc-def", ""} {
+		s := New(Config{Path: filepath.Join(t.TempDir(), "queue.db"), Table: bad})
+		if err := s.Init(); err == nil {
+			t.Errorf("Init with table %q: got nil error, want rejection", bad)
+		}
+	}
+}
+
+func TestInitAcceptsValidTableName(t *testing.T) {
+	s := New(Config{Path: filepath.Join(t.TempDir(), "queue.db"), Table: "my_queue_1"})
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init with valid table name: %v", err)
+	}
+	s.Close()
+}